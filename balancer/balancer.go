@@ -0,0 +1,177 @@
+// Package balancer provides ready-made backend selection strategies for
+// proxy.ProxyOptions.Balancer: RoundRobin, Random, LeastConn and Weighted.
+// Each strategy's Select method matches the Balancer function signature
+// directly (pass it as a method value, e.g. opts.Balancer = rr.Select), and
+// optionally supports active health checks and passive ejection of
+// misbehaving backends.
+package balancer
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultEjectAfter is the number of failures MarkFailure needs to see
+// before a backend is temporarily removed from rotation.
+const defaultEjectAfter = 5
+
+// defaultCooldown is how long an ejected backend stays out of rotation
+// before being eligible again.
+const defaultCooldown = 30 * time.Second
+
+// state tracks the health/ejection bookkeeping for a single backend.
+type state struct {
+	mu sync.Mutex
+
+	healthy        bool
+	consecutiveErr int
+	ejectedUntil   time.Time
+}
+
+func newState() *state {
+	return &state{healthy: true}
+}
+
+func (s *state) available(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.healthy {
+		return false
+	}
+
+	if !s.ejectedUntil.IsZero() {
+		if now.Before(s.ejectedUntil) {
+			return false
+		}
+		// Cooldown has passed: give the backend a clean slate instead of
+		// leaving consecutiveErr at ejectAfter, where a single subsequent
+		// failure would instantly re-eject it for another full cooldown.
+		s.ejectedUntil = time.Time{}
+		s.consecutiveErr = 0
+	}
+
+	return true
+}
+
+func (s *state) markFailure(ejectAfter int, cooldown time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.consecutiveErr++
+	if s.consecutiveErr >= ejectAfter {
+		s.ejectedUntil = now.Add(cooldown)
+	}
+}
+
+func (s *state) setHealthy(healthy bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.healthy = healthy
+}
+
+// pool is the shared backend list and health/ejection state embedded by
+// every strategy below.
+type pool struct {
+	backends []string
+	states   map[string]*state
+
+	ejectAfter int
+	cooldown   time.Duration
+
+	stop chan struct{}
+}
+
+func newPool(backends []string) *pool {
+	states := make(map[string]*state, len(backends))
+	for _, b := range backends {
+		states[b] = newState()
+	}
+
+	return &pool{
+		backends:   backends,
+		states:     states,
+		ejectAfter: defaultEjectAfter,
+		cooldown:   defaultCooldown,
+	}
+}
+
+// MarkFailure records an observed failure for backend, passively ejecting
+// it once EjectAfter failures have been seen since it was last restored.
+// Successful requests are not reported to the pool and do not reset the
+// count, so the failures need not be consecutive. Wire it to
+// proxy.ProxyOptions.OnBackendFailure.
+func (p *pool) MarkFailure(backend string) {
+	if s, ok := p.states[backend]; ok {
+		s.markFailure(p.ejectAfter, p.cooldown, time.Now())
+	}
+}
+
+// EjectAfter overrides how many failures MarkFailure needs to see before
+// ejecting a backend. Defaults to 5.
+func (p *pool) EjectAfter(n int) {
+	p.ejectAfter = n
+}
+
+// Cooldown overrides how long an ejected backend stays out of rotation.
+// Defaults to 30s.
+func (p *pool) Cooldown(d time.Duration) {
+	p.cooldown = d
+}
+
+// available returns the backends currently eligible for selection: healthy
+// and not within their ejection cooldown.
+func (p *pool) available() []string {
+	now := time.Now()
+	out := make([]string, 0, len(p.backends))
+	for _, b := range p.backends {
+		if p.states[b].available(now) {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// HealthCheck starts a background goroutine that GETs path on every backend
+// every interval, marking a backend unhealthy on failure or a 5xx response
+// and healthy again once it recovers. Call Stop to end it.
+func (p *pool) HealthCheck(path string, interval time.Duration) {
+	p.stop = make(chan struct{})
+	go p.healthCheckLoop(path, interval)
+}
+
+func (p *pool) healthCheckLoop(path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	client := &http.Client{Timeout: interval}
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			for _, backend := range p.backends {
+				go p.checkOne(client, backend, path)
+			}
+		}
+	}
+}
+
+func (p *pool) checkOne(client *http.Client, backend, path string) {
+	resp, err := client.Get(backend + path)
+	healthy := err == nil && resp.StatusCode < 500
+	if resp != nil {
+		resp.Body.Close()
+	}
+	p.states[backend].setHealthy(healthy)
+}
+
+// Stop ends the active health check goroutine started by HealthCheck, if
+// any.
+func (p *pool) Stop() {
+	if p.stop != nil {
+		close(p.stop)
+	}
+}
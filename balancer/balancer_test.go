@@ -0,0 +1,33 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+)
+
+// TestStateEjectionCooldown verifies that markFailure ejects a backend once
+// it reaches ejectAfter consecutive failures, that it becomes available
+// again once cooldown has passed, and that a single failure right after
+// recovery doesn't instantly re-eject it.
+func TestStateEjectionCooldown(t *testing.T) {
+	s := newState()
+	now := time.Now()
+	cooldown := 10 * time.Millisecond
+
+	for i := 0; i < 5; i++ {
+		s.markFailure(5, cooldown, now)
+	}
+	if s.available(now) {
+		t.Fatal("expected backend to be ejected after reaching ejectAfter consecutive failures")
+	}
+
+	afterCooldown := now.Add(cooldown + time.Millisecond)
+	if !s.available(afterCooldown) {
+		t.Fatal("expected backend to be available again once cooldown has passed")
+	}
+
+	s.markFailure(5, cooldown, afterCooldown)
+	if !s.available(afterCooldown) {
+		t.Fatal("expected a single post-cooldown failure not to instantly re-eject the backend")
+	}
+}
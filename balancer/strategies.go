@@ -0,0 +1,165 @@
+package balancer
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errNoBackends is returned by Select when every backend is unhealthy or
+// within its ejection cooldown.
+var errNoBackends = errors.New("balancer: no healthy backends available")
+
+// RoundRobinBalancer cycles through the available backends in order.
+type RoundRobinBalancer struct {
+	*pool
+
+	mu   sync.Mutex
+	next int
+}
+
+// RoundRobin returns a Balancer that cycles through backends in order,
+// skipping any currently ejected or marked unhealthy.
+func RoundRobin(backends []string) *RoundRobinBalancer {
+	return &RoundRobinBalancer{pool: newPool(backends)}
+}
+
+// Select implements proxy.ProxyOptions.Balancer.
+func (b *RoundRobinBalancer) Select(req *http.Request) (string, error) {
+	available := b.available()
+	if len(available) == 0 {
+		return "", errNoBackends
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	backend := available[b.next%len(available)]
+	b.next++
+	return backend, nil
+}
+
+// RandomBalancer picks a uniformly random backend for every request.
+type RandomBalancer struct {
+	*pool
+}
+
+// Random returns a Balancer that picks a uniformly random backend,
+// skipping any currently ejected or marked unhealthy.
+func Random(backends []string) *RandomBalancer {
+	return &RandomBalancer{pool: newPool(backends)}
+}
+
+// Select implements proxy.ProxyOptions.Balancer.
+func (b *RandomBalancer) Select(req *http.Request) (string, error) {
+	available := b.available()
+	if len(available) == 0 {
+		return "", errNoBackends
+	}
+	return available[rand.Intn(len(available))], nil
+}
+
+// LeastConnBalancer picks the available backend with the fewest in-flight
+// connections, as reported via Inc/Dec.
+type LeastConnBalancer struct {
+	*pool
+
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+// LeastConn returns a Balancer that picks whichever available backend
+// currently has the fewest in-flight connections. Wire its Inc/Dec methods
+// to proxy.ProxyOptions.OnBackendStart/OnBackendDone to track them.
+func LeastConn(backends []string) *LeastConnBalancer {
+	return &LeastConnBalancer{
+		pool:  newPool(backends),
+		conns: make(map[string]int, len(backends)),
+	}
+}
+
+// Select implements proxy.ProxyOptions.Balancer.
+func (b *LeastConnBalancer) Select(req *http.Request) (string, error) {
+	available := b.available()
+	if len(available) == 0 {
+		return "", errNoBackends
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	best := available[0]
+	for _, backend := range available[1:] {
+		if b.conns[backend] < b.conns[best] {
+			best = backend
+		}
+	}
+	return best, nil
+}
+
+// Inc records a new in-flight connection to backend.
+func (b *LeastConnBalancer) Inc(backend string) {
+	b.mu.Lock()
+	b.conns[backend]++
+	b.mu.Unlock()
+}
+
+// Dec records that an in-flight connection to backend has finished.
+func (b *LeastConnBalancer) Dec(backend string) {
+	b.mu.Lock()
+	if b.conns[backend] > 0 {
+		b.conns[backend]--
+	}
+	b.mu.Unlock()
+}
+
+// WeightedBalancer distributes requests across backends proportionally to
+// their configured weight.
+type WeightedBalancer struct {
+	*pool
+
+	mu       sync.Mutex
+	next     int
+	expanded []string
+}
+
+// Weighted returns a Balancer that distributes requests across backends
+// proportionally to the integer weight given for each.
+func Weighted(weights map[string]int) *WeightedBalancer {
+	backends := make([]string, 0, len(weights))
+	expanded := make([]string, 0, len(weights))
+	for backend, weight := range weights {
+		backends = append(backends, backend)
+		for i := 0; i < weight; i++ {
+			expanded = append(expanded, backend)
+		}
+	}
+
+	return &WeightedBalancer{
+		pool:     newPool(backends),
+		expanded: expanded,
+	}
+}
+
+// Select implements proxy.ProxyOptions.Balancer.
+func (b *WeightedBalancer) Select(req *http.Request) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.expanded) == 0 {
+		return "", errNoBackends
+	}
+
+	// Walk the weighted expansion at most once around, skipping any
+	// backend that's currently ejected or unhealthy.
+	for i := 0; i < len(b.expanded); i++ {
+		backend := b.expanded[b.next%len(b.expanded)]
+		b.next++
+		if b.states[backend].available(time.Now()) {
+			return backend, nil
+		}
+	}
+	return "", errNoBackends
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import "net/http"
+
+// HeaderOps describes literal header mutations: Set overwrites a header,
+// Add appends an additional value and Del removes a header outright. Del
+// runs last, so a header present in both Set/Add and Del ends up removed.
+type HeaderOps struct {
+	Set map[string]string
+	Add map[string][]string
+	Del []string
+}
+
+func (h HeaderOps) apply(header http.Header) {
+	for k, v := range h.Set {
+		header.Set(k, v)
+	}
+	for k, vs := range h.Add {
+		for _, v := range vs {
+			header.Add(k, v)
+		}
+	}
+	for _, k := range h.Del {
+		header.Del(k)
+	}
+}
+
+// modifyResponse applies ResponseHeaders and then the user-supplied
+// ModifyResponse hook, if any. Used both as httputil.ReverseProxy's
+// ModifyResponse and, equivalently, on the buffered websocket response.
+func (p *Proxy) modifyResponse(resp *http.Response) error {
+	p.ResponseHeaders.apply(resp.Header)
+
+	if p.ModifyResponse != nil {
+		return p.ModifyResponse(resp)
+	}
+	return nil
+}
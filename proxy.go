@@ -1,29 +1,92 @@
 package proxy
 
 import (
+	"crypto/tls"
 	"errors"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
 	"time"
-
-	"github.com/gorilla/websocket"
-	"github.com/koding/websocketproxy"
 )
 
 type ProxyOptions struct {
 	// Number of times a request should be tried
 	Retries int
 
-	// Period to wait between retries
+	// Period to wait between retries, doubled on every subsequent attempt
 	Period time.Duration
 
+	// Upper bound on the backoff delay between retries, regardless of
+	// how many attempts have been made. Defaults to 2s.
+	MaxPeriod time.Duration
+
+	// Status codes that should trigger a retry. Defaults to 502, 503 and 504.
+	RetryStatusCodes []int
+
+	// By default only idempotent methods (GET/HEAD/OPTIONS/PUT/DELETE) are
+	// retried. Set this to also retry POST requests.
+	ForcePOSTRetry bool
+
+	// OnRetry, when set, is called before every retry attempt, for both
+	// the HTTP and websocket paths.
+	OnRetry func(attempt int, err error, req *http.Request)
+
 	// Returns a url that we should proxy to for a given request
 	Balancer func(req *http.Request) (string, error)
 
 	// A static backend to route to
 	Backend string
+
+	// TLS config used when dialing https/wss backends. Cloned and
+	// defaulted per connection, so it's safe to share across backends.
+	TLSClientConfig *tls.Config
+
+	// Skip backend certificate verification. Useful for private CAs during
+	// development; prefer TLSClientConfig.RootCAs in production.
+	InsecureSkipVerify bool
+
+	// BackendServerName, when set, overrides the Host header and TLS
+	// ServerName/SNI sent to the backend. Without it both default to the
+	// backend URL's host, which is wrong when a Balancer returns IP:port
+	// targets for a backend that routes purely on Host.
+	BackendServerName func(req *http.Request) string
+
+	// Literal header mutations applied to the outgoing request and the
+	// backend's response, respectively.
+	RequestHeaders  HeaderOps
+	ResponseHeaders HeaderOps
+
+	// ModifyRequest, when set, runs last in director, after the backend
+	// rewrite, forwarded headers and RequestHeaders have been applied. An
+	// error aborts the request the same way a Balancer error does.
+	ModifyRequest func(req *http.Request) error
+
+	// ModifyResponse, when set, runs after ResponseHeaders on both the
+	// HTTP and websocket response paths. An error fails the request.
+	ModifyResponse func(resp *http.Response) error
+
+	// By default X-Forwarded-For/Host/Proto are set from what gogo-proxy
+	// itself observes, discarding any values the client sent. Set this to
+	// append to/preserve them instead, for deployments behind a trusted
+	// upstream proxy or load balancer.
+	TrustForwardHeader bool
+
+	// OnBackendFailure, when set, is called with the raw backend string
+	// (exactly what Balancer returned) for every failed attempt, whether or
+	// not it gets retried. The MarkFailure method of any balancer package
+	// strategy fits this directly, so passive ejection can react to retries it didn't
+	// observe itself.
+	OnBackendFailure func(backend string)
+
+	// OnBackendStart/OnBackendDone, when set, bracket each attempt to a
+	// backend: OnBackendStart fires right after a backend is selected,
+	// OnBackendDone once its response (HTTP) or hijacked connection
+	// (websocket) is finished. balancer.LeastConn's Inc/Dec methods fit
+	// these directly to track in-flight connections per backend.
+	OnBackendStart func(backend string)
+	OnBackendDone  func(backend string)
 }
 
 type Proxy struct {
@@ -61,6 +124,11 @@ func New(opts ProxyOptions) (*Proxy, error) {
 		opts.Period = 100 * time.Millisecond
 	}
 
+	// Default for MaxPeriod
+	if opts.MaxPeriod == 0 {
+		opts.MaxPeriod = 2 * time.Second
+	}
+
 	p := &Proxy{
 		ProxyOptions: &opts,
 	}
@@ -71,8 +139,6 @@ func New(opts ProxyOptions) (*Proxy, error) {
 // ServeHTTP allows us to comply to the http.Handler interface
 func (p *Proxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	if isWebsocket(req) {
-		// we don't use https explicitly, ssl termination is done here
-		req.URL.Scheme = "ws"
 		p.websocketProxy.ServeHTTP(rw, req)
 		return
 	}
@@ -84,32 +150,64 @@ func (p *Proxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 func (p *Proxy) init() *Proxy {
 	// Setup http proxy
 	p.httpProxy = &httputil.ReverseProxy{
-		Director: p.director,
+		Director:  p.director,
+		Transport: &retryTransport{p: p, next: p.newTransport()},
+		ModifyResponse: func(resp *http.Response) error {
+			p.reportBackendDone(resp.Request)
+			return p.modifyResponse(resp)
+		},
 	}
 
 	// Setup websocket proxy
-	p.websocketProxy = &websocketproxy.WebsocketProxy{
-		Backend: func(req *http.Request) *url.URL {
-			url, _ := p.backend(req)
-			return url
-		},
-		Upgrader: &websocket.Upgrader{
-			ReadBufferSize:  4096,
-			WriteBufferSize: 4096,
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
-		},
-	}
+	p.websocketProxy = http.HandlerFunc(p.serveWebsocket)
 
 	return p
 }
 
-// director rewrites a http.Request to route to the correct host
+// director rewrites a http.Request to route to the correct host. It runs
+// exactly once per inbound request (as httputil.ReverseProxy.Director, or
+// explicitly once before the websocket dial loop) and applies the one-time
+// RequestHeaders/ModifyRequest pipeline. Use redirectBackend instead for any
+// per-attempt rewrite on a retry, since re-running RequestHeaders/
+// ModifyRequest per attempt would duplicate Add entries and re-invoke
+// non-idempotent hooks.
 func (p *Proxy) director(req *http.Request) {
+	// Snapshot the real inbound Host once: redirectBackend can run again on
+	// retries, by which point req.Host has already been overwritten to the
+	// previous attempt's backend, not the original client Host.
+	withOriginalHost(req, req.Host)
+
+	// Ignore the error here: req.URL.Host is still whatever the inbound
+	// request arrived with (typically empty), which already fails to dial
+	// the same way a ModifyRequest error below does. Retry call sites, in
+	// contrast, must check it themselves to avoid reusing a stale backend.
+	_ = p.redirectBackend(req)
+
+	p.RequestHeaders.apply(req.Header)
+
+	if p.ModifyRequest != nil {
+		if err := p.ModifyRequest(req); err != nil {
+			// Mirror the backend-selection failure below: leave the
+			// request without a dialable host so it fails the same way.
+			req.URL.Host = ""
+			return
+		}
+	}
+}
+
+// redirectBackend rewrites req's URL, Host, TLS ServerName and forwarded
+// headers to whatever backend Balancer picks for this attempt. Unlike
+// director, it's safe to call repeatedly across retry attempts. It returns
+// the Balancer's error, if any, so a retry loop can stop instead of firing
+// another attempt at req's previous, stale backend.
+func (p *Proxy) redirectBackend(req *http.Request) error {
 	url, err := p.backend(req)
 	if url == nil || err != nil {
-		return
+		// Leave req's routing state exactly as it was (on the first call,
+		// still unrouted, which already fails to dial) and report the
+		// failure so a retry loop can stop instead of firing another
+		// attempt at the previous, now-stale backend.
+		return err
 	}
 
 	// Rewrite outgoing request url
@@ -118,6 +216,66 @@ func (p *Proxy) director(req *http.Request) {
 	req.URL.Path = url.Path
 
 	req.Host = url.Host
+
+	// Default SNI/Host to the backend's own host, unless BackendServerName
+	// overrides it (e.g. the backend is an IP:port but routes on Host).
+	serverName := stripPort(url.Host)
+	if p.BackendServerName != nil {
+		if name := p.BackendServerName(req); name != "" {
+			req.Host = name
+			serverName = name
+		}
+	}
+
+	if p.TLSClientConfig != nil || p.InsecureSkipVerify || p.BackendServerName != nil {
+		withBackendServerName(req, serverName)
+	}
+
+	p.setForwardedHeaders(req, originalHostFrom(req))
+
+	return nil
+}
+
+// setForwardedHeaders populates X-Forwarded-For/Host/Proto. Unless
+// TrustForwardHeader is set, any values the client sent are discarded in
+// favor of what gogo-proxy itself observes.
+func (p *Proxy) setForwardedHeaders(req *http.Request, originalHost string) {
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		clientIP = req.RemoteAddr
+	}
+
+	if clientIP != "" {
+		if prior := req.Header.Get("X-Forwarded-For"); prior != "" && p.TrustForwardHeader {
+			req.Header.Set("X-Forwarded-For", prior+", "+clientIP)
+		} else {
+			req.Header.Set("X-Forwarded-For", clientIP)
+		}
+	}
+
+	if existing := req.Header.Get("X-Forwarded-Host"); !p.TrustForwardHeader || existing == "" {
+		req.Header.Set("X-Forwarded-Host", originalHost)
+	}
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	if p.TrustForwardHeader {
+		if existing := req.Header.Get("X-Forwarded-Proto"); existing != "" {
+			proto = existing
+		}
+	}
+	req.Header.Set("X-Forwarded-Proto", proto)
+}
+
+// stripPort removes a ":port" suffix from a host, for use as a TLS SNI
+// ServerName, which must not include the port.
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
 }
 
 // backend parses the result of getBackend and ensures it's validity
@@ -127,6 +285,14 @@ func (p *Proxy) backend(req *http.Request) (*url.URL, error) {
 		return nil, err
 	}
 
+	// Remember the raw backend string (exactly as returned by Balancer) on
+	// req's context, so later failure/completion hooks can report on the
+	// same backend without calling Balancer again.
+	withBackendRaw(req, rawurl)
+	if p.OnBackendStart != nil {
+		p.OnBackendStart(rawurl)
+	}
+
 	// Normalize URL
 	backendUrl := normalizeUrl(rawurl)
 
@@ -196,21 +362,6 @@ func normalizeUrl(rawurl string) string {
 	return parsed.String()
 }
 
-// websocketScheme picks a suitable websocket scheme
-func websocketScheme(scheme string) string {
-	switch scheme {
-		case "http":
-			return "ws"
-		case "https":
-			return "wss"
-		case "ws":
-		case "wss":
-			return scheme
-	}
-	// Default
-	return "ws"
-}
-
 // httpScheme picks a suitable http scheme
 func httpScheme(scheme string) string {
 	switch scheme {
@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// defaultRetryStatusCodes are the response status codes that trigger a
+// retry when ProxyOptions.RetryStatusCodes isn't set.
+var defaultRetryStatusCodes = map[int]bool{
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// idempotentMethods are retried by default; POST is only retried when
+// ForcePOSTRetry is set.
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// retryTransport wraps an http.RoundTripper, buffering the request body once
+// and re-invoking Proxy.redirectBackend on every retry so a Balancer can route the
+// next attempt to a different backend.
+type retryTransport struct {
+	p    *Proxy
+	next http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.p.shouldRetry(req) {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil && !t.p.retryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+
+		// No further attempt is coming to report this itself: mirror the
+		// loop below so OnBackendFailure/OnBackendDone still fire when
+		// retries are disabled (Retries defaults to 1) or the method isn't
+		// retried (e.g. POST without ForcePOSTRetry).
+		t.p.reportBackendFailure(req)
+		if err != nil {
+			// No response reaches ReverseProxy's ModifyResponse on a
+			// transport error, so report Done ourselves.
+			t.p.reportBackendDone(req)
+		}
+		return resp, err
+	}
+
+	body, err := bufferBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *http.Response
+	for attempt := 0; attempt < t.p.Retries; attempt++ {
+		if attempt > 0 {
+			// redirectBackend only, not director: RequestHeaders/
+			// ModifyRequest already ran once, before the first attempt.
+			if berr := t.p.redirectBackend(req); berr != nil {
+				// The Balancer itself failed (e.g. every backend is
+				// currently ejected): req.URL.Host still points at the
+				// previous, just-failed backend, so stop instead of
+				// retrying against stale routing state.
+				err = berr
+				break
+			}
+			resetBody(req, body)
+
+			if t.p.OnRetry != nil {
+				t.p.OnRetry(attempt, err, req)
+			}
+
+			time.Sleep(t.p.backoff(attempt - 1))
+		}
+
+		resp, err = t.next.RoundTrip(req)
+		if err == nil && !t.p.retryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+
+		t.p.reportBackendFailure(req)
+
+		last := attempt == t.p.Retries-1
+		if !last {
+			// Retrying away from this backend onto a fresh one; its Start
+			// is done. A surviving final attempt has its Done reported by
+			// the ModifyResponse wrapper in init instead.
+			t.p.reportBackendDone(req)
+			if resp != nil {
+				resp.Body.Close()
+			}
+		} else if err != nil {
+			// No response reaches ReverseProxy's ModifyResponse on a
+			// transport error, so report Done ourselves.
+			t.p.reportBackendDone(req)
+		}
+	}
+
+	return resp, err
+}
+
+// shouldRetry reports whether req is eligible for retries at all, based on
+// Retries and the request method.
+func (p *Proxy) shouldRetry(req *http.Request) bool {
+	if p.Retries <= 1 {
+		return false
+	}
+	if idempotentMethods[req.Method] {
+		return true
+	}
+	return req.Method == http.MethodPost && p.ForcePOSTRetry
+}
+
+// retryableStatus reports whether code should trigger a retry.
+func (p *Proxy) retryableStatus(code int) bool {
+	if len(p.RetryStatusCodes) == 0 {
+		return defaultRetryStatusCodes[code]
+	}
+	for _, c := range p.RetryStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay to wait before retry attempt n+1 (0-indexed),
+// doubling Period on every attempt up to MaxPeriod. The result is jittered:
+// a random delay between half of that value and all of it.
+func (p *Proxy) backoff(attempt int) time.Duration {
+	d := p.Period
+	for i := 0; i < attempt; i++ {
+		d *= 2
+		if d >= p.MaxPeriod {
+			break
+		}
+	}
+	if d > p.MaxPeriod {
+		d = p.MaxPeriod
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(d)/2 + 1))
+	return d/2 + jitter
+}
+
+// reportBackendFailure calls OnBackendFailure with the backend req's current
+// attempt used, if the hook is set.
+func (p *Proxy) reportBackendFailure(req *http.Request) {
+	if p.OnBackendFailure == nil {
+		return
+	}
+	if raw, ok := req.Context().Value(backendRawKey).(string); ok {
+		p.OnBackendFailure(raw)
+	}
+}
+
+// reportBackendDone calls OnBackendDone with the backend req's current
+// attempt used, if the hook is set.
+func (p *Proxy) reportBackendDone(req *http.Request) {
+	if p.OnBackendDone == nil {
+		return
+	}
+	if raw, ok := req.Context().Value(backendRawKey).(string); ok {
+		p.OnBackendDone(raw)
+	}
+}
+
+// bufferBody reads req.Body fully so it can be replayed across retries.
+func bufferBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// resetBody rewinds req.Body to a fresh reader over the buffered bytes.
+func resetBody(req *http.Request, body []byte) {
+	if body == nil {
+		req.Body = nil
+		return
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+}
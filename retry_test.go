@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// roundTripperFunc adapts a function to http.RoundTripper for tests.
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// TestRetryTransportReportsOnNonRetriedPath verifies that OnBackendFailure/
+// OnBackendDone still fire on a transport error when Retries is at its
+// default of 1 (shouldRetry's fast path), since no later attempt or
+// ModifyResponse call is coming to report it otherwise.
+func TestRetryTransportReportsOnNonRetriedPath(t *testing.T) {
+	var failures, dones int
+
+	p := &Proxy{ProxyOptions: &ProxyOptions{
+		Retries: 1,
+		Backend: "http://backend-a",
+		OnBackendFailure: func(backend string) {
+			failures++
+		},
+		OnBackendDone: func(backend string) {
+			dones++
+		},
+	}}
+
+	req := httptest.NewRequest(http.MethodGet, "http://client.example/path", nil)
+	p.director(req)
+
+	transport := &retryTransport{p: p, next: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("dial refused")
+	})}
+
+	if _, err := transport.RoundTrip(req); err == nil {
+		t.Fatal("expected the transport error to be returned")
+	}
+
+	if failures != 1 {
+		t.Errorf("OnBackendFailure calls = %d, want 1", failures)
+	}
+	if dones != 1 {
+		t.Errorf("OnBackendDone calls = %d, want 1", dones)
+	}
+}
+
+// TestRetryTransportAbortsOnBalancerError verifies that a Balancer error on
+// a retry stops the loop instead of reusing the previous, stale backend.
+func TestRetryTransportAbortsOnBalancerError(t *testing.T) {
+	var calls int
+	errNoBackends := errors.New("no backends available")
+
+	p := &Proxy{ProxyOptions: &ProxyOptions{
+		Retries:   3,
+		Period:    time.Millisecond,
+		MaxPeriod: time.Millisecond,
+		Balancer: func(req *http.Request) (string, error) {
+			calls++
+			if calls == 1 {
+				return "http://backend-a", nil
+			}
+			return "", errNoBackends
+		},
+	}}
+
+	req := httptest.NewRequest(http.MethodGet, "http://client.example/path", nil)
+	p.director(req)
+
+	transport := &retryTransport{p: p, next: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("dial refused")
+	})}
+
+	_, err := transport.RoundTrip(req)
+	if err != errNoBackends {
+		t.Errorf("RoundTrip error = %v, want %v", err, errNoBackends)
+	}
+	if calls != 2 {
+		t.Errorf("Balancer calls = %d, want 2 (one retry attempt after the error)", calls)
+	}
+	if req.URL.Host != "backend-a" {
+		t.Errorf("req.URL.Host = %q, want it left at the last successfully routed backend", req.URL.Host)
+	}
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+)
+
+// contextKey namespaces values this package stores on a request's context.
+type contextKey int
+
+const (
+	// backendServerNameKey carries the SNI/Host override computed by
+	// director, for the transport's DialTLSContext and the websocket
+	// dialer to pick up.
+	backendServerNameKey contextKey = iota
+
+	// backendRawKey carries the raw backend string Balancer returned for
+	// the current attempt, for the retry/failure-reporting hooks.
+	backendRawKey
+
+	// originalHostKey carries the inbound request's original Host, snapshot
+	// once so retries (which overwrite req.Host with the previous attempt's
+	// backend) still compute X-Forwarded-Host from the real client value.
+	originalHostKey
+)
+
+// withBackendServerName attaches name to req's context. It mutates req in
+// place (the same *req = *req.WithContext(...) idiom net/http itself uses),
+// since director can't change the *http.Request it was handed.
+func withBackendServerName(req *http.Request, name string) {
+	*req = *req.WithContext(context.WithValue(req.Context(), backendServerNameKey, name))
+}
+
+// withBackendRaw attaches the raw backend string to req's context, using
+// the same in-place mutation idiom as withBackendServerName.
+func withBackendRaw(req *http.Request, raw string) {
+	*req = *req.WithContext(context.WithValue(req.Context(), backendRawKey, raw))
+}
+
+// withOriginalHost attaches the inbound request's original Host, using the
+// same in-place mutation idiom as withBackendServerName.
+func withOriginalHost(req *http.Request, host string) {
+	*req = *req.WithContext(context.WithValue(req.Context(), originalHostKey, host))
+}
+
+// originalHostFrom returns the Host snapshot withOriginalHost attached, or
+// req.Host itself if none was ever attached (e.g. a single-attempt request).
+func originalHostFrom(req *http.Request) string {
+	if h, ok := req.Context().Value(originalHostKey).(string); ok {
+		return h
+	}
+	return req.Host
+}
+
+// tlsConfigFor builds the *tls.Config to use when connecting to a backend
+// with the given SNI ServerName, honoring TLSClientConfig/InsecureSkipVerify.
+func (p *Proxy) tlsConfigFor(serverName string) *tls.Config {
+	var cfg *tls.Config
+	if p.TLSClientConfig != nil {
+		cfg = p.TLSClientConfig.Clone()
+	} else {
+		cfg = &tls.Config{}
+	}
+
+	if cfg.ServerName == "" {
+		cfg.ServerName = serverName
+	}
+	if p.InsecureSkipVerify {
+		cfg.InsecureSkipVerify = true
+	}
+
+	return cfg
+}
+
+// newTransport returns the http.RoundTripper the reverse proxy dials
+// backends with. It only diverges from http.DefaultTransport when TLS
+// options are configured, since DialTLSContext needs to read the
+// per-request ServerName director attached to the context.
+func (p *Proxy) newTransport() http.RoundTripper {
+	if p.TLSClientConfig == nil && !p.InsecureSkipVerify && p.BackendServerName == nil {
+		return http.DefaultTransport
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if p.BackendServerName != nil {
+		// http.Transport pools keep-alive connections by addr alone, not by
+		// the per-request ServerName DialTLSContext reads below. With
+		// BackendServerName multiple logical backends can share one addr
+		// (that's the whole point of it), so a pooled connection opened for
+		// one backend's SNI could get silently reused for another's.
+		// Disable keep-alives so every request dials (and picks its SNI)
+		// fresh instead of risking a stale connection.
+		transport.DisableKeepAlives = true
+	}
+
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		serverName, _ := ctx.Value(backendServerNameKey).(string)
+		if serverName == "" {
+			serverName = stripPort(addr)
+		}
+
+		tlsConn := tls.Client(conn, p.tlsConfigFor(serverName))
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return tlsConn, nil
+	}
+
+	return transport
+}
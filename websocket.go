@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// serveWebsocket proxies a websocket upgrade request by hand: it dials the
+// backend over a raw connection, replays the (possibly rewritten) client
+// request on it, reads the raw response, hijacks the client connection and
+// then shuttles bytes in both directions. Unlike decoding and re-encoding
+// every frame through a websocket client, this can't lose data the backend
+// writes in the same packet as its 101 response.
+func (p *Proxy) serveWebsocket(rw http.ResponseWriter, req *http.Request) {
+	// Clone before director/redirectBackend mutate anything: unlike
+	// httputil.ReverseProxy, which clones the inbound request before calling
+	// Director, this handler is wired straight to the server, so without
+	// this the rewritten URL/Host/headers and swapped context would leak
+	// back to req and be visible to any middleware wrapping this handler.
+	backendReq := req.Clone(req.Context())
+
+	backendConn, backendReader, err := p.dialWebsocketBackend(backendReq)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer backendConn.Close()
+	defer p.reportBackendDone(backendReq)
+
+	if err := backendReq.Write(backendConn); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	resp, err := http.ReadResponse(backendReader, backendReq)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if err := p.modifyResponse(resp); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := rw.(http.Hijacker)
+	if !ok {
+		http.Error(rw, "websocket: response writer doesn't support hijacking", http.StatusInternalServerError)
+		return
+	}
+
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer clientConn.Close()
+
+	if err := resp.Write(clientConn); err != nil {
+		return
+	}
+
+	// Copying from backendReader (rather than backendConn directly) drains
+	// any bytes already buffered from the backend's initial response packet
+	// before falling through to further reads, so frames sent right after
+	// the 101 aren't lost. Same reasoning for clientBuf on the other side.
+	done := make(chan struct{}, 2)
+	go copyAndSignal(done, backendConn, clientBuf)
+	go copyAndSignal(done, clientConn, backendReader)
+	<-done
+}
+
+func copyAndSignal(done chan<- struct{}, dst io.Writer, src io.Reader) {
+	io.Copy(dst, src)
+	done <- struct{}{}
+}
+
+// dialWebsocketBackend picks a backend via director/getBackend and dials it
+// over a raw TCP or TLS connection, retrying with the same backoff as the
+// HTTP retry transport and giving the Balancer a chance to pick a different
+// backend on each attempt.
+func (p *Proxy) dialWebsocketBackend(req *http.Request) (net.Conn, *bufio.Reader, error) {
+	// director runs once, for the first attempt: it applies the one-time
+	// RequestHeaders/ModifyRequest pipeline in addition to picking a
+	// backend. Retries only re-pick a backend, via redirectBackend.
+	p.director(req)
+
+	var lastErr error
+
+	for attempt := 0; attempt < p.Retries; attempt++ {
+		if attempt > 0 {
+			if p.OnRetry != nil {
+				p.OnRetry(attempt, lastErr, req)
+			}
+
+			time.Sleep(p.backoff(attempt - 1))
+
+			if err := p.redirectBackend(req); err != nil {
+				// The Balancer itself failed (e.g. every backend is
+				// currently ejected): req.URL.Host still points at the
+				// previous, just-failed backend, so stop instead of
+				// retrying against stale routing state.
+				lastErr = err
+				break
+			}
+		}
+
+		conn, reader, err := p.dialWebsocketBackendOnce(req)
+		if err == nil {
+			return conn, reader, nil
+		}
+
+		p.reportBackendFailure(req)
+		p.reportBackendDone(req)
+		lastErr = err
+	}
+
+	return nil, nil, lastErr
+}
+
+// dialWebsocketBackendOnce makes a single dial attempt to req's current
+// backend, as already rewritten onto req.URL by director or redirectBackend.
+func (p *Proxy) dialWebsocketBackendOnce(req *http.Request) (net.Conn, *bufio.Reader, error) {
+	addr := req.URL.Host
+	if addr == "" {
+		// Unrouted request: don't let ":80" dial the local machine.
+		return nil, nil, errors.New("websocket: no backend host in request URL")
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if req.URL.Scheme == "https" {
+			addr = net.JoinHostPort(addr, "443")
+		} else {
+			addr = net.JoinHostPort(addr, "80")
+		}
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if req.URL.Scheme == "https" {
+		serverName, _ := req.Context().Value(backendServerNameKey).(string)
+		if serverName == "" {
+			serverName = stripPort(addr)
+		}
+
+		tlsConn := tls.Client(conn, p.tlsConfigFor(serverName))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn = tlsConn
+	}
+
+	return conn, bufio.NewReader(conn), nil
+}